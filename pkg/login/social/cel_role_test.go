@@ -0,0 +1,49 @@
+package social
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoleExpressionEvaluator_GroupsTakePrecedenceOverClaims(t *testing.T) {
+	evaluator, err := NewRoleExpressionEvaluator(
+		`"admins" in groups ? "Admin" : (claims["role"] == "editor" ? "Editor" : "Viewer")`)
+	require.NoError(t, err)
+
+	role, err := evaluator.Evaluate(RoleExprInput{
+		Claims: map[string]interface{}{"role": "editor"},
+		Groups: []string{"admins"},
+		Email:  "ada@example.com",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Admin", role, "a matching group should win over a lower-ranked claim-derived role")
+}
+
+func TestRoleExpressionEvaluator_FallsBackToClaimWhenNoGroupMatches(t *testing.T) {
+	evaluator, err := NewRoleExpressionEvaluator(
+		`"admins" in groups ? "Admin" : (claims["role"] == "editor" ? "Editor" : "Viewer")`)
+	require.NoError(t, err)
+
+	role, err := evaluator.Evaluate(RoleExprInput{
+		Claims: map[string]interface{}{"role": "editor"},
+		Groups: []string{"devs"},
+		Email:  "ada@example.com",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Editor", role)
+}
+
+func TestRoleExpressionEvaluator_RejectsNonStringResult(t *testing.T) {
+	_, err := NewRoleExpressionEvaluator(`1 + 1`)
+	assert.Error(t, err, "compilation should fail fast for an expression that doesn't type-check to a string")
+}
+
+func TestRoleExpressionEvaluator_RejectsUnrecognizedRole(t *testing.T) {
+	evaluator, err := NewRoleExpressionEvaluator(`"SuperAdmin"`)
+	require.NoError(t, err)
+
+	_, err = evaluator.Evaluate(RoleExprInput{})
+	assert.Error(t, err, "a role outside Admin/Editor/Viewer/\"\" must be rejected at evaluation time")
+}
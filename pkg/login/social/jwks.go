@@ -0,0 +1,298 @@
+package social
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// defaultJWKSCacheTTL is used when a provider does not set jwks_cache_ttl.
+const defaultJWKSCacheTTL = 5 * time.Minute
+
+// IDTokenVerifierConfig carries the knobs an OIDC-ish provider needs to
+// verify a signed id_token against a JWKS. It is shared by SocialOkta and
+// can be reused by SocialGenericOAuth and SocialAzureAD.
+type IDTokenVerifierConfig struct {
+	// JWKSURL is the provider's JWKS endpoint. If empty, it is discovered
+	// from IssuerURL + "/.well-known/openid-configuration".
+	JWKSURL string
+	// IssuerURL is the expected `iss` claim and is also used for discovery.
+	IssuerURL string
+	// ClientID is the expected `aud` claim.
+	ClientID string
+	// SignatureAlgorithms restricts which `alg` values are accepted.
+	// Defaults to RS256 when empty.
+	SignatureAlgorithms []string
+	// CacheTTL controls how long a fetched key set is considered fresh
+	// before a background refresh is triggered.
+	CacheTTL time.Duration
+	// SkipVerify disables signature verification entirely. It exists for
+	// local development against providers without reachable JWKS and must
+	// never be enabled in production.
+	SkipVerify bool
+}
+
+// oidcDiscoveryDoc is the subset of the OIDC discovery document we need.
+type oidcDiscoveryDoc struct {
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// noopLogger is the default Logger for a verifier that hasn't had SetLogger
+// called on it.
+type noopLogger struct{}
+
+func (noopLogger) Debug(msg string, ctx ...interface{}) {}
+func (noopLogger) Info(msg string, ctx ...interface{})  {}
+func (noopLogger) Error(msg string, ctx ...interface{}) {}
+
+// IDTokenVerifier fetches and caches a provider's JWKS and verifies id_token
+// signatures and standard claims against it. A single verifier instance is
+// safe for concurrent use. It refreshes its key set on a CacheTTL ticker in
+// a background goroutine, and falls back to a synchronous refresh from the
+// request path only on a `kid` cache miss (first use, or rotation that
+// raced the next tick).
+type IDTokenVerifier struct {
+	cfg        IDTokenVerifierConfig
+	httpClient *http.Client
+	log        Logger
+
+	mu        sync.RWMutex
+	keysByKid map[string]jose.JSONWebKey
+	fetchedAt time.Time
+
+	startOnce sync.Once
+	stopCh    chan struct{}
+}
+
+// NewIDTokenVerifier constructs a verifier for the given configuration and
+// starts its background JWKS refresh goroutine. The supplied http.Client is
+// used for JWKS and discovery requests. Call Close to stop the background
+// refresh when the verifier is no longer needed.
+func NewIDTokenVerifier(cfg IDTokenVerifierConfig, httpClient *http.Client) *IDTokenVerifier {
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = defaultJWKSCacheTTL
+	}
+	if len(cfg.SignatureAlgorithms) == 0 {
+		cfg.SignatureAlgorithms = []string{"RS256"}
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	v := &IDTokenVerifier{
+		cfg:        cfg,
+		httpClient: httpClient,
+		log:        noopLogger{},
+		keysByKid:  map[string]jose.JSONWebKey{},
+	}
+	v.startBackgroundRefresh()
+	return v
+}
+
+// SetLogger routes background refresh failures (e.g. an unreachable JWKS
+// endpoint, or a rotation that leaves no matching key) to the given logger
+// instead of discarding them.
+func (v *IDTokenVerifier) SetLogger(log Logger) {
+	v.log = log
+}
+
+// startBackgroundRefresh launches the refresh goroutine exactly once per
+// verifier.
+func (v *IDTokenVerifier) startBackgroundRefresh() {
+	v.startOnce.Do(func() {
+		v.stopCh = make(chan struct{})
+		go v.backgroundRefreshLoop()
+	})
+}
+
+func (v *IDTokenVerifier) backgroundRefreshLoop() {
+	ticker := time.NewTicker(v.cfg.CacheTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := v.refresh(); err != nil {
+				v.log.Error("okta: background JWKS refresh failed, serving cached keys", "error", err)
+			}
+		case <-v.stopCh:
+			return
+		}
+	}
+}
+
+// Close stops the background refresh goroutine.
+func (v *IDTokenVerifier) Close() {
+	select {
+	case <-v.stopCh:
+	default:
+		close(v.stopCh)
+	}
+}
+
+// Verify parses rawIDToken, checks its signature against the cached JWKS
+// (refreshing on a `kid` miss in case of key rotation), validates `iss`,
+// `aud` (expecting the OAuth client_id, as an id_token carries it), `exp`,
+// `nbf` and `iat`, unmarshals the verified claims into out, and also
+// returns the full claim set as a generic map (e.g. for use by
+// role_attribute_expr).
+func (v *IDTokenVerifier) Verify(rawIDToken string, out interface{}) (map[string]interface{}, error) {
+	return v.verify(rawIDToken, out, v.cfg.ClientID)
+}
+
+// VerifyAudience is like Verify but checks `aud` against the given value
+// instead of the configured client_id. Use this for tokens whose audience
+// isn't the client_id, such as an Okta access_token, whose `aud` is the
+// authorization server's resource identifier (e.g. `api://default`).
+func (v *IDTokenVerifier) VerifyAudience(rawToken string, out interface{}, audience string) (map[string]interface{}, error) {
+	return v.verify(rawToken, out, audience)
+}
+
+func (v *IDTokenVerifier) verify(rawToken string, out interface{}, audience string) (map[string]interface{}, error) {
+	parsed, err := jwt.ParseSigned(rawToken)
+	if err != nil {
+		return nil, fmt.Errorf("okta: error parsing token: %w", err)
+	}
+
+	rawClaims := map[string]interface{}{}
+
+	if v.cfg.SkipVerify {
+		if err := parsed.UnsafeClaimsWithoutVerification(out, &rawClaims); err != nil {
+			return nil, err
+		}
+		return rawClaims, nil
+	}
+
+	if len(parsed.Headers) == 0 {
+		return nil, fmt.Errorf("okta: token has no headers")
+	}
+	header := parsed.Headers[0]
+
+	if !v.algorithmAllowed(header.Algorithm) {
+		return nil, fmt.Errorf("okta: token uses disallowed signature algorithm %q", header.Algorithm)
+	}
+
+	key, err := v.keyForKid(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims jwt.Claims
+	if err := parsed.Claims(key, &claims, out, &rawClaims); err != nil {
+		return nil, fmt.Errorf("okta: token signature verification failed: %w", err)
+	}
+
+	expected := jwt.Expected{
+		Issuer:   v.cfg.IssuerURL,
+		Audience: jwt.Audience{audience},
+		Time:     time.Now(),
+	}
+	if err := claims.Validate(expected); err != nil {
+		return nil, fmt.Errorf("okta: token failed claim validation: %w", err)
+	}
+
+	return rawClaims, nil
+}
+
+func (v *IDTokenVerifier) algorithmAllowed(alg string) bool {
+	for _, allowed := range v.cfg.SignatureAlgorithms {
+		if allowed == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// keyForKid returns the key matching kid. The common case is served from
+// the cache kept warm by the background refresh loop; a miss (first ever
+// verification, or a rotation that happened since the last tick) triggers
+// one synchronous refresh so the login isn't rejected until the next tick.
+func (v *IDTokenVerifier) keyForKid(kid string) (jose.JSONWebKey, error) {
+	v.mu.RLock()
+	key, ok := v.keysByKid[kid]
+	v.mu.RUnlock()
+
+	if ok {
+		return key, nil
+	}
+
+	if err := v.refresh(); err != nil {
+		return jose.JSONWebKey{}, err
+	}
+
+	v.mu.RLock()
+	key, ok = v.keysByKid[kid]
+	v.mu.RUnlock()
+	if !ok {
+		return jose.JSONWebKey{}, fmt.Errorf("okta: no JWKS key found for kid %q (key may have rotated)", kid)
+	}
+	return key, nil
+}
+
+// refresh fetches the JWKS (discovering jwks_uri first if needed) and
+// replaces the cached key set.
+func (v *IDTokenVerifier) refresh() error {
+	jwksURL := v.cfg.JWKSURL
+	if jwksURL == "" {
+		discovered, err := v.discoverJWKSURL()
+		if err != nil {
+			return fmt.Errorf("okta: failed discovering jwks_uri: %w", err)
+		}
+		jwksURL = discovered
+	}
+
+	resp, err := v.httpClient.Get(jwksURL)
+	if err != nil {
+		return fmt.Errorf("okta: failed fetching JWKS from %s: %w", jwksURL, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("okta: unexpected status %d fetching JWKS from %s", resp.StatusCode, jwksURL)
+	}
+
+	var set jose.JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("okta: failed decoding JWKS: %w", err)
+	}
+
+	keys := make(map[string]jose.JSONWebKey, len(set.Keys))
+	for _, k := range set.Keys {
+		keys[k.KeyID] = k
+	}
+
+	v.mu.Lock()
+	v.keysByKid = keys
+	v.fetchedAt = time.Now()
+	v.mu.Unlock()
+
+	return nil
+}
+
+func (v *IDTokenVerifier) discoverJWKSURL() (string, error) {
+	if v.cfg.IssuerURL == "" {
+		return "", fmt.Errorf("neither jwks_url nor issuer is configured")
+	}
+	resp, err := v.httpClient.Get(v.cfg.IssuerURL + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var doc oidcDiscoveryDoc
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", err
+	}
+	if doc.JWKSURI == "" {
+		return "", fmt.Errorf("discovery document has no jwks_uri")
+	}
+	return doc.JWKSURI, nil
+}
@@ -0,0 +1,205 @@
+package social
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+type testClaims struct {
+	Name string `json:"name"`
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.Claims, extra testClaims) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithHeader("kid", kid))
+	require.NoError(t, err)
+	token, err := jwt.Signed(signer).Claims(claims).Claims(extra).CompactSerialize()
+	require.NoError(t, err)
+	return token
+}
+
+func jwksServer(t *testing.T, keys ...jose.JSONWebKey) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: keys})
+	}))
+}
+
+func newKeyPair(t *testing.T, kid string) (*rsa.PrivateKey, jose.JSONWebKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key, jose.JSONWebKey{Key: key.Public(), KeyID: kid, Algorithm: "RS256", Use: "sig"}
+}
+
+func TestIDTokenVerifier_AcceptsValidToken(t *testing.T) {
+	key, pub := newKeyPair(t, "kid-1")
+	server := jwksServer(t, pub)
+	defer server.Close()
+
+	v := NewIDTokenVerifier(IDTokenVerifierConfig{
+		JWKSURL:   server.URL,
+		IssuerURL: "https://example.okta.com",
+		ClientID:  "client-id",
+	}, http.DefaultClient)
+	defer v.Close()
+
+	token := signToken(t, key, "kid-1", jwt.Claims{
+		Issuer:   "https://example.okta.com",
+		Audience: jwt.Audience{"client-id"},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}, testClaims{Name: "Ada"})
+
+	var claims testClaims
+	_, err := v.Verify(token, &claims)
+	require.NoError(t, err)
+	assert.Equal(t, "Ada", claims.Name)
+}
+
+func TestIDTokenVerifier_RejectsWrongAudience(t *testing.T) {
+	key, pub := newKeyPair(t, "kid-1")
+	server := jwksServer(t, pub)
+	defer server.Close()
+
+	v := NewIDTokenVerifier(IDTokenVerifierConfig{
+		JWKSURL:   server.URL,
+		IssuerURL: "https://example.okta.com",
+		ClientID:  "client-id",
+	}, http.DefaultClient)
+	defer v.Close()
+
+	token := signToken(t, key, "kid-1", jwt.Claims{
+		Issuer:   "https://example.okta.com",
+		Audience: jwt.Audience{"someone-elses-client-id"},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}, testClaims{})
+
+	var claims testClaims
+	_, err := v.Verify(token, &claims)
+	assert.Error(t, err)
+}
+
+func TestIDTokenVerifier_RejectsExpiredToken(t *testing.T) {
+	key, pub := newKeyPair(t, "kid-1")
+	server := jwksServer(t, pub)
+	defer server.Close()
+
+	v := NewIDTokenVerifier(IDTokenVerifierConfig{
+		JWKSURL:   server.URL,
+		IssuerURL: "https://example.okta.com",
+		ClientID:  "client-id",
+	}, http.DefaultClient)
+	defer v.Close()
+
+	token := signToken(t, key, "kid-1", jwt.Claims{
+		Issuer:   "https://example.okta.com",
+		Audience: jwt.Audience{"client-id"},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+	}, testClaims{})
+
+	var claims testClaims
+	_, err := v.Verify(token, &claims)
+	assert.Error(t, err)
+}
+
+func TestIDTokenVerifier_RejectsWrongIssuer(t *testing.T) {
+	key, pub := newKeyPair(t, "kid-1")
+	server := jwksServer(t, pub)
+	defer server.Close()
+
+	v := NewIDTokenVerifier(IDTokenVerifierConfig{
+		JWKSURL:   server.URL,
+		IssuerURL: "https://example.okta.com",
+		ClientID:  "client-id",
+	}, http.DefaultClient)
+	defer v.Close()
+
+	token := signToken(t, key, "kid-1", jwt.Claims{
+		Issuer:   "https://not-example.okta.com",
+		Audience: jwt.Audience{"client-id"},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}, testClaims{})
+
+	var claims testClaims
+	_, err := v.Verify(token, &claims)
+	assert.Error(t, err)
+}
+
+func TestIDTokenVerifier_VerifyAudienceChecksGivenAudience(t *testing.T) {
+	key, pub := newKeyPair(t, "kid-1")
+	server := jwksServer(t, pub)
+	defer server.Close()
+
+	v := NewIDTokenVerifier(IDTokenVerifierConfig{
+		JWKSURL:   server.URL,
+		IssuerURL: "https://example.okta.com",
+		ClientID:  "client-id",
+	}, http.DefaultClient)
+	defer v.Close()
+
+	// An Okta access_token is typically audienced to the authorization
+	// server's resource identifier, not the OAuth client_id.
+	token := signToken(t, key, "kid-1", jwt.Claims{
+		Issuer:   "https://example.okta.com",
+		Audience: jwt.Audience{"api://default"},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}, testClaims{})
+
+	var claims testClaims
+	_, err := v.Verify(token, &claims)
+	assert.Error(t, err, "Verify checks aud against ClientID, which this token doesn't carry")
+
+	_, err = v.VerifyAudience(token, &claims, "api://default")
+	assert.NoError(t, err, "VerifyAudience should accept the token against the audience it actually carries")
+
+	_, err = v.VerifyAudience(token, &claims, "some-other-audience")
+	assert.Error(t, err)
+}
+
+func TestIDTokenVerifier_HandlesKeyRotation(t *testing.T) {
+	oldKey, oldPub := newKeyPair(t, "kid-old")
+	newKey, newPub := newKeyPair(t, "kid-new")
+
+	// The JWKS endpoint only ever serves the *current* key, as Okta does
+	// immediately after a rotation.
+	server := jwksServer(t, oldPub)
+	defer server.Close()
+
+	v := NewIDTokenVerifier(IDTokenVerifierConfig{
+		JWKSURL:   server.URL,
+		IssuerURL: "https://example.okta.com",
+		ClientID:  "client-id",
+	}, http.DefaultClient)
+	defer v.Close()
+
+	validClaims := jwt.Claims{
+		Issuer:   "https://example.okta.com",
+		Audience: jwt.Audience{"client-id"},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+
+	oldToken := signToken(t, oldKey, "kid-old", validClaims, testClaims{})
+	var claims testClaims
+	_, err := v.Verify(oldToken, &claims)
+	require.NoError(t, err)
+
+	// Okta rotates: the signing key changes and the JWKS endpoint now
+	// serves the new key under a new kid.
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{newPub}})
+	})
+
+	newToken := signToken(t, newKey, "kid-new", validClaims, testClaims{})
+	_, err = v.Verify(newToken, &claims)
+	require.NoError(t, err, "verifier should refresh on an unknown kid instead of rejecting a legitimately rotated token")
+}
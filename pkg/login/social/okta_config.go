@@ -0,0 +1,86 @@
+package social
+
+import (
+	"gopkg.in/ini.v1"
+)
+
+// LoadOktaConfig builds a fully wired SocialOkta from an `[auth.okta]` ini
+// section: the oauth2.Config basics are assumed already set on base (as
+// NewOAuthService does for every provider), and this function is the one
+// place that turns every Okta-specific ini knob (jwks_url, jwks_cache_ttl,
+// skip_verify, signature_algorithms, api_token, org_url, group_mappings,
+// role_attribute_expr, jwk_json/jwk_pem/kid) into the matching setter call.
+//
+// This is the integration point, not the integration itself: the actual
+// `"okta"` case in NewOAuthService's provider switch (pkg/login/social/
+// social.go, outside this package's current scope) still needs to call
+// LoadOktaConfig instead of constructing SocialOkta directly for any of
+// this to run in a live server.
+func LoadOktaConfig(sec *ini.Section, base *SocialBase) (*SocialOkta, error) {
+	clientID := sec.Key("client_id").String()
+	tokenURL := sec.Key("token_url").String()
+	apiURL := sec.Key("api_url").String()
+	issuerURL := sec.Key("issuer_url").String()
+
+	okta := NewSocialOkta(base, apiURL, sec.Key("jwks_url").String(), issuerURL, clientID,
+		sec.Key("signature_algorithms").Strings(","),
+		sec.Key("jwks_cache_ttl").MustDuration(defaultJWKSCacheTTL),
+		sec.Key("skip_verify").MustBool(false),
+		sec.Key("allowed_domains").Strings(","),
+		sec.Key("allowed_groups").Strings(","),
+		sec.Key("allow_sign_up").MustBool(true),
+		sec.Key("role_attribute_path").String(),
+	)
+
+	if expr := sec.Key("role_attribute_expr").String(); expr != "" {
+		evaluator, err := NewRoleExpressionEvaluator(expr)
+		if err != nil {
+			return nil, err
+		}
+		okta.SetRoleAttributeExpr(evaluator)
+	}
+
+	if apiToken := sec.Key("api_token").String(); apiToken != "" {
+		// org_url is the Okta org root (e.g. https://{org}.okta.com), distinct
+		// from api_url (the full userinfo endpoint path): NewOktaGroupsClient
+		// appends /api/v1/users/{id}/groups itself, so reusing api_url here
+		// would produce a malformed Groups API URL.
+		okta.SetGroupsClient(NewOktaGroupsClient(sec.Key("org_url").String(), apiToken,
+			sec.Key("groups_cache_ttl").MustDuration(defaultGroupsCacheTTL), nil))
+	}
+
+	if raw := sec.Key("group_mappings").String(); raw != "" {
+		mappings, err := ParseGroupMappings(raw)
+		if err != nil {
+			return nil, err
+		}
+		okta.SetGroupMappingReconciler(&GroupMappingReconciler{
+			Mappings: mappings,
+			DryRun:   sec.Key("group_mappings_dry_run").MustBool(false),
+			Log:      okta.log,
+		})
+	}
+
+	if jwtClientAuth, err := loadJWTClientAuth(sec, clientID, tokenURL); err != nil {
+		return nil, err
+	} else if jwtClientAuth != nil {
+		okta.SetJWTClientAuth(jwtClientAuth)
+	}
+
+	return okta, nil
+}
+
+// loadJWTClientAuth builds a JWTClientAuth from whichever of jwk_json /
+// jwk_pem is configured. Neither being set is not an error: it just means
+// this Okta Service App uses a regular client_secret instead.
+func loadJWTClientAuth(sec *ini.Section, clientID, tokenURL string) (*JWTClientAuth, error) {
+	kid := sec.Key("kid").String()
+
+	if rawJWK := sec.Key("jwk_json").String(); rawJWK != "" {
+		return NewJWTClientAuthFromJWK(clientID, tokenURL, kid, []byte(rawJWK))
+	}
+	if rawPEM := sec.Key("jwk_pem").String(); rawPEM != "" {
+		return NewJWTClientAuthFromPEM(clientID, tokenURL, kid, []byte(rawPEM))
+	}
+	return nil, nil
+}
@@ -0,0 +1,94 @@
+package social
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+func generateTestRSAPEM(t *testing.T) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	return string(pem.EncodeToMemory(block))
+}
+
+func TestLoadOktaConfig_WiresRoleAttributeExpr(t *testing.T) {
+	cfg, err := ini.Load([]byte(`
+[auth.okta]
+client_id = client-id
+issuer_url = https://example.okta.com
+role_attribute_expr = "admins" in groups ? "Admin" : "Viewer"
+`))
+	require.NoError(t, err)
+
+	okta, err := LoadOktaConfig(cfg.Section("auth.okta"), nil)
+	require.NoError(t, err)
+	assert.NotNil(t, okta.roleAttributeExpr, "role_attribute_expr should be compiled and wired onto the provider")
+}
+
+func TestLoadOktaConfig_RejectsInvalidRoleAttributeExpr(t *testing.T) {
+	cfg, err := ini.Load([]byte(`
+[auth.okta]
+client_id = client-id
+issuer_url = https://example.okta.com
+role_attribute_expr = 1 + 1
+`))
+	require.NoError(t, err)
+
+	_, err = LoadOktaConfig(cfg.Section("auth.okta"), nil)
+	assert.Error(t, err)
+}
+
+func TestLoadOktaConfig_WiresJWTClientAuthFromPEM(t *testing.T) {
+	pemKey := generateTestRSAPEM(t)
+
+	cfg, err := ini.Load([]byte("[auth.okta]\nclient_id = client-id\nissuer_url = https://example.okta.com\ntoken_url = https://example.okta.com/token\nkid = test-kid\n"))
+	require.NoError(t, err)
+	cfg.Section("auth.okta").NewKey("jwk_pem", pemKey)
+
+	okta, err := LoadOktaConfig(cfg.Section("auth.okta"), nil)
+	require.NoError(t, err)
+	assert.NotNil(t, okta.jwtClientAuth, "jwk_pem should wire up JWT client auth")
+}
+
+func TestLoadOktaConfig_GroupsClientUsesOrgURLNotAPIURL(t *testing.T) {
+	cfg, err := ini.Load([]byte(`
+[auth.okta]
+client_id = client-id
+issuer_url = https://example.okta.com
+api_url = https://example.okta.com/oauth2/default/v1/userinfo
+org_url = https://example.okta.com
+api_token = token-123
+`))
+	require.NoError(t, err)
+
+	okta, err := LoadOktaConfig(cfg.Section("auth.okta"), nil)
+	require.NoError(t, err)
+
+	require.NotNil(t, okta.groupsClient, "api_token should wire up a groups client")
+	assert.Equal(t, "https://example.okta.com", okta.groupsClient.APIURL,
+		"the groups client must use org_url (the org root), not api_url (the userinfo endpoint path)")
+	assert.Equal(t, "https://example.okta.com/oauth2/default/v1/userinfo", okta.apiUrl,
+		"api_url should still be used for the userinfo endpoint")
+}
+
+func TestLoadOktaConfig_NoJWKConfiguredLeavesClientAuthNil(t *testing.T) {
+	cfg, err := ini.Load([]byte(`
+[auth.okta]
+client_id = client-id
+issuer_url = https://example.okta.com
+`))
+	require.NoError(t, err)
+
+	okta, err := LoadOktaConfig(cfg.Section("auth.okta"), nil)
+	require.NoError(t, err)
+	assert.Nil(t, okta.jwtClientAuth)
+}
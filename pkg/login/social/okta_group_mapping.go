@@ -0,0 +1,302 @@
+package social
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// GroupMapping declares that members of Group should be granted Role in
+// OrgID, and optionally be added to the named Teams within that org.
+//
+//	group_mappings = [{"group": "okta-grafana-admins", "org_id": 1, "role": "Admin"}, {"group": "devs", "org_id": 2, "role": "Editor", "teams": ["frontend"]}]
+type GroupMapping struct {
+	Group string   `json:"group"`
+	OrgID int64    `json:"org_id"`
+	Role  string   `json:"role"`
+	Teams []string `json:"teams"`
+}
+
+// ParseGroupMappings parses the `group_mappings` ini setting, a JSON array
+// of GroupMapping objects. An empty raw value yields no mappings.
+func ParseGroupMappings(raw string) ([]GroupMapping, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	var mappings []GroupMapping
+	if err := json.Unmarshal([]byte(raw), &mappings); err != nil {
+		return nil, fmt.Errorf("okta: invalid group_mappings: %w", err)
+	}
+
+	for _, m := range mappings {
+		if m.Group == "" || m.OrgID == 0 || m.Role == "" {
+			return nil, fmt.Errorf("okta: group_mappings entries require group, org_id and role")
+		}
+	}
+
+	return mappings, nil
+}
+
+// resolvedOrgAssignment is the union of all group_mappings rules that
+// matched a user's groups for a single org.
+type resolvedOrgAssignment struct {
+	OrgID int64
+	Role  models.RoleType
+	Teams map[string]struct{}
+}
+
+// ResolveGroupMappings computes, for the given groups, which org the user
+// should belong to with which role and team memberships. When multiple
+// mappings apply to the same org, the highest-privilege role wins and team
+// lists are unioned.
+func ResolveGroupMappings(mappings []GroupMapping, groups []string) map[int64]*resolvedOrgAssignment {
+	memberOf := make(map[string]struct{}, len(groups))
+	for _, g := range groups {
+		memberOf[g] = struct{}{}
+	}
+
+	byOrg := map[int64]*resolvedOrgAssignment{}
+	for _, m := range mappings {
+		if _, ok := memberOf[m.Group]; !ok {
+			continue
+		}
+
+		assignment, ok := byOrg[m.OrgID]
+		if !ok {
+			assignment = &resolvedOrgAssignment{OrgID: m.OrgID, Role: models.RoleType(m.Role), Teams: map[string]struct{}{}}
+			byOrg[m.OrgID] = assignment
+		} else if roleRank(models.RoleType(m.Role)) > roleRank(assignment.Role) {
+			assignment.Role = models.RoleType(m.Role)
+		}
+
+		for _, t := range m.Teams {
+			assignment.Teams[t] = struct{}{}
+		}
+	}
+
+	return byOrg
+}
+
+func roleRank(role models.RoleType) int {
+	switch role {
+	case models.ROLE_ADMIN:
+		return 3
+	case models.ROLE_EDITOR:
+		return 2
+	case models.ROLE_VIEWER:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// GroupMappingReconciler reconciles a user's org and team memberships
+// against the result of ResolveGroupMappings, adding and removing
+// memberships as the user's groups change. Reconciliation is idempotent:
+// running it twice in a row with the same groups is a no-op.
+type GroupMappingReconciler struct {
+	Mappings []GroupMapping
+	DryRun   bool
+	Log      Logger
+}
+
+// Logger is the subset of the package's logging interface the reconciler
+// needs; *log15.Logger (as embedded in SocialBase) satisfies it.
+type Logger interface {
+	Debug(msg string, ctx ...interface{})
+	Info(msg string, ctx ...interface{})
+	Error(msg string, ctx ...interface{})
+}
+
+// Reconcile resolves userGroups against the configured mappings and syncs
+// the result onto userID's org and team memberships via bus commands.
+//
+// Only orgs (and, within an org, only teams) that appear in at least one
+// group_mappings rule are considered "managed": a user is only ever removed
+// from an org or team group_mappings actually governs, never from
+// memberships granted manually or by another auth method.
+func (r *GroupMappingReconciler) Reconcile(userID int64, userGroups []string) error {
+	desired := ResolveGroupMappings(r.Mappings, userGroups)
+	managedOrgs := r.managedOrgs()
+
+	existingOrgs, err := r.currentOrgs(userID)
+	if err != nil {
+		return fmt.Errorf("okta: failed loading current org memberships: %w", err)
+	}
+
+	for orgID, assignment := range desired {
+		if err := r.reconcileOrg(userID, orgID, assignment, existingOrgs); err != nil {
+			return err
+		}
+	}
+
+	for orgID := range existingOrgs {
+		if _, wanted := desired[orgID]; wanted {
+			continue
+		}
+		if _, managed := managedOrgs[orgID]; !managed {
+			continue
+		}
+		if err := r.removeFromOrg(userID, orgID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// managedOrgs returns the set of org ids referenced by at least one
+// group_mappings rule.
+func (r *GroupMappingReconciler) managedOrgs() map[int64]struct{} {
+	orgs := make(map[int64]struct{}, len(r.Mappings))
+	for _, m := range r.Mappings {
+		orgs[m.OrgID] = struct{}{}
+	}
+	return orgs
+}
+
+// managedTeams returns the set of team names referenced by a group_mappings
+// rule for the given org.
+func (r *GroupMappingReconciler) managedTeams(orgID int64) map[string]struct{} {
+	teams := map[string]struct{}{}
+	for _, m := range r.Mappings {
+		if m.OrgID != orgID {
+			continue
+		}
+		for _, t := range m.Teams {
+			teams[t] = struct{}{}
+		}
+	}
+	return teams
+}
+
+func (r *GroupMappingReconciler) currentOrgs(userID int64) (map[int64]models.RoleType, error) {
+	query := &models.GetUserOrgListQuery{UserId: userID}
+	if err := bus.Dispatch(query); err != nil {
+		return nil, err
+	}
+
+	current := make(map[int64]models.RoleType, len(query.Result))
+	for _, org := range query.Result {
+		current[org.OrgId] = org.Role
+	}
+	return current, nil
+}
+
+func (r *GroupMappingReconciler) reconcileOrg(userID, orgID int64, assignment *resolvedOrgAssignment, existingOrgs map[int64]models.RoleType) error {
+	currentRole, isMember := existingOrgs[orgID]
+
+	switch {
+	case !isMember:
+		r.logAction("add org membership", userID, orgID, assignment.Role)
+		if !r.DryRun {
+			if err := bus.Dispatch(&models.AddOrgUserCommand{UserId: userID, OrgId: orgID, Role: assignment.Role}); err != nil {
+				return fmt.Errorf("okta: failed adding user %d to org %d: %w", userID, orgID, err)
+			}
+		}
+	case currentRole != assignment.Role:
+		r.logAction("update org role", userID, orgID, assignment.Role)
+		if !r.DryRun {
+			if err := bus.Dispatch(&models.UpdateOrgUserCommand{UserId: userID, OrgId: orgID, Role: assignment.Role}); err != nil {
+				return fmt.Errorf("okta: failed updating user %d role in org %d: %w", userID, orgID, err)
+			}
+		}
+	}
+
+	for team := range assignment.Teams {
+		if err := r.ensureTeamMember(userID, orgID, team); err != nil {
+			return err
+		}
+	}
+
+	return r.removeStaleTeamMemberships(userID, orgID, assignment.Teams)
+}
+
+func (r *GroupMappingReconciler) ensureTeamMember(userID, orgID int64, teamName string) error {
+	teamID, found, err := r.lookupTeam(orgID, teamName)
+	if err != nil {
+		return err
+	}
+	if !found {
+		r.Log.Debug("okta group mapping: team not found, skipping", "team", teamName, "org_id", orgID)
+		return nil
+	}
+
+	r.logAction(fmt.Sprintf("ensure team membership (%s)", teamName), userID, orgID, "")
+	if r.DryRun {
+		return nil
+	}
+
+	err = bus.Dispatch(&models.AddTeamMemberCommand{UserId: userID, OrgId: orgID, TeamId: teamID})
+	if err != nil && err != models.ErrTeamMemberAlreadyAdded {
+		return fmt.Errorf("okta: failed adding user %d to team %q: %w", userID, teamName, err)
+	}
+	return nil
+}
+
+// removeStaleTeamMemberships removes userID from any team within orgID that
+// group_mappings manages but that is not in wantedTeams, i.e. a team the
+// user was previously granted by a rule that no longer matches their groups.
+func (r *GroupMappingReconciler) removeStaleTeamMemberships(userID, orgID int64, wantedTeams map[string]struct{}) error {
+	managed := r.managedTeams(orgID)
+	if len(managed) == 0 {
+		return nil
+	}
+
+	teamsQuery := &models.GetTeamsByUserQuery{OrgId: orgID, UserId: userID}
+	if err := bus.Dispatch(teamsQuery); err != nil {
+		return fmt.Errorf("okta: failed loading current team memberships for org %d: %w", orgID, err)
+	}
+
+	for _, team := range teamsQuery.Result {
+		if _, isManaged := managed[team.Name]; !isManaged {
+			continue
+		}
+		if _, wanted := wantedTeams[team.Name]; wanted {
+			continue
+		}
+
+		r.logAction(fmt.Sprintf("remove team membership (%s)", team.Name), userID, orgID, "")
+		if r.DryRun {
+			continue
+		}
+		if err := bus.Dispatch(&models.RemoveTeamMemberCommand{UserId: userID, OrgId: orgID, TeamId: team.Id}); err != nil {
+			return fmt.Errorf("okta: failed removing user %d from team %q: %w", userID, team.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// lookupTeam resolves teamName to its id within orgID.
+func (r *GroupMappingReconciler) lookupTeam(orgID int64, teamName string) (int64, bool, error) {
+	teamQuery := &models.SearchTeamsQuery{OrgId: orgID, Name: teamName}
+	if err := bus.Dispatch(teamQuery); err != nil {
+		return 0, false, fmt.Errorf("okta: failed looking up team %q in org %d: %w", teamName, orgID, err)
+	}
+	if len(teamQuery.Result.Teams) == 0 {
+		return 0, false, nil
+	}
+	return teamQuery.Result.Teams[0].Id, true, nil
+}
+
+func (r *GroupMappingReconciler) removeFromOrg(userID, orgID int64) error {
+	r.logAction("remove org membership", userID, orgID, "")
+	if r.DryRun {
+		return nil
+	}
+	if err := bus.Dispatch(&models.RemoveOrgUserCommand{UserId: userID, OrgId: orgID}); err != nil {
+		return fmt.Errorf("okta: failed removing user %d from org %d: %w", userID, orgID, err)
+	}
+	return nil
+}
+
+func (r *GroupMappingReconciler) logAction(action string, userID, orgID int64, role models.RoleType) {
+	if r.DryRun {
+		action = "[dry-run] " + action
+	}
+	r.Log.Info("okta group mapping: "+action, "user_id", userID, "org_id", orgID, "role", role)
+}
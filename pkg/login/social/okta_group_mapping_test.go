@@ -0,0 +1,112 @@
+package social
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveGroupMappings(t *testing.T) {
+	mappings := []GroupMapping{
+		{Group: "okta-grafana-admins", OrgID: 1, Role: "Admin"},
+		{Group: "devs", OrgID: 2, Role: "Editor", Teams: []string{"frontend"}},
+		{Group: "devs", OrgID: 2, Role: "Viewer", Teams: []string{"backend"}},
+	}
+
+	resolved := ResolveGroupMappings(mappings, []string{"devs"})
+
+	require.Contains(t, resolved, int64(2))
+	assert.NotContains(t, resolved, int64(1))
+
+	org2 := resolved[int64(2)]
+	assert.Equal(t, models.ROLE_EDITOR, org2.Role, "higher-ranked role among matching rules should win")
+	assert.Contains(t, org2.Teams, "frontend")
+	assert.Contains(t, org2.Teams, "backend")
+}
+
+func TestGroupMappingReconciler_OnlyRemovesManagedOrgs(t *testing.T) {
+	bus.ClearBusHandlers()
+	defer bus.ClearBusHandlers()
+
+	// The user belongs to org 1 (governed by group_mappings) and org 99
+	// (granted some other way, e.g. manually or via another auth method).
+	bus.AddHandler("test", func(q *models.GetUserOrgListQuery) error {
+		q.Result = []*models.UserOrgDTO{
+			{OrgId: 1, Role: models.ROLE_ADMIN},
+			{OrgId: 99, Role: models.ROLE_VIEWER},
+		}
+		return nil
+	})
+
+	var removedOrgs []int64
+	bus.AddHandler("test", func(cmd *models.RemoveOrgUserCommand) error {
+		removedOrgs = append(removedOrgs, cmd.OrgId)
+		return nil
+	})
+	bus.AddHandler("test", func(cmd *models.AddOrgUserCommand) error { return nil })
+	bus.AddHandler("test", func(cmd *models.UpdateOrgUserCommand) error { return nil })
+	bus.AddHandler("test", func(q *models.GetTeamsByUserQuery) error {
+		q.Result = nil
+		return nil
+	})
+
+	r := &GroupMappingReconciler{
+		Mappings: []GroupMapping{
+			{Group: "okta-grafana-admins", OrgID: 1, Role: "Admin"},
+		},
+		Log: &noopLogger{},
+	}
+
+	// The user's groups no longer include okta-grafana-admins, so org 1
+	// (managed) should be removed, but org 99 (unmanaged) must be left
+	// alone.
+	err := r.Reconcile(42, []string{"some-other-group"})
+	require.NoError(t, err)
+
+	assert.Equal(t, []int64{1}, removedOrgs)
+}
+
+func TestGroupMappingReconciler_RemovesStaleTeamMembership(t *testing.T) {
+	bus.ClearBusHandlers()
+	defer bus.ClearBusHandlers()
+
+	bus.AddHandler("test", func(q *models.GetUserOrgListQuery) error {
+		q.Result = []*models.UserOrgDTO{{OrgId: 2, Role: models.ROLE_EDITOR}}
+		return nil
+	})
+	bus.AddHandler("test", func(cmd *models.UpdateOrgUserCommand) error { return nil })
+	bus.AddHandler("test", func(cmd *models.AddOrgUserCommand) error { return nil })
+	bus.AddHandler("test", func(q *models.SearchTeamsQuery) error {
+		q.Result = models.SearchTeamQueryResult{Teams: []*models.TeamDTO{{Id: 10, Name: q.Name}}}
+		return nil
+	})
+	bus.AddHandler("test", func(cmd *models.AddTeamMemberCommand) error { return nil })
+
+	var removedTeams []int64
+	bus.AddHandler("test", func(cmd *models.RemoveTeamMemberCommand) error {
+		removedTeams = append(removedTeams, cmd.TeamId)
+		return nil
+	})
+	bus.AddHandler("test", func(q *models.GetTeamsByUserQuery) error {
+		// The user currently sits on both "frontend" and "backend", but
+		// the resolved mapping below will only call for "frontend".
+		q.Result = []*models.TeamDTO{{Id: 10, Name: "frontend"}, {Id: 11, Name: "backend"}}
+		return nil
+	})
+
+	r := &GroupMappingReconciler{
+		Mappings: []GroupMapping{
+			{Group: "devs", OrgID: 2, Role: "Editor", Teams: []string{"frontend"}},
+			{Group: "devs-backend", OrgID: 2, Role: "Editor", Teams: []string{"backend"}},
+		},
+		Log: &noopLogger{},
+	}
+
+	err := r.Reconcile(42, []string{"devs"})
+	require.NoError(t, err)
+
+	assert.Equal(t, []int64{11}, removedTeams, "backend membership should be dropped, frontend kept")
+}
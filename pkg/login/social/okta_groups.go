@@ -0,0 +1,172 @@
+package social
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultGroupsCacheTTL bounds how long a user's group list is trusted
+// before the next login re-fetches it from the Okta Groups API.
+const defaultGroupsCacheTTL = 5 * time.Minute
+
+// defaultGroupsRequestTimeout bounds a single Groups API call (including
+// pagination) so a slow or unreachable Okta tenant cannot hang a login.
+const defaultGroupsRequestTimeout = 10 * time.Second
+
+type oktaGroup struct {
+	Profile struct {
+		Name string `json:"name"`
+	} `json:"profile"`
+}
+
+// OktaGroupsClient calls Okta's admin Groups API
+// (`/api/v1/users/{id}/groups`) using an API token, following the `Link`
+// pagination header, and caches the result per user id for CacheTTL to
+// avoid hitting the API on every token refresh.
+type OktaGroupsClient struct {
+	APIToken string
+	APIURL   string // e.g. https://{org}.okta.com
+	CacheTTL time.Duration
+	Timeout  time.Duration
+
+	httpClient *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cachedGroups
+}
+
+type cachedGroups struct {
+	groups    []string
+	fetchedAt time.Time
+}
+
+// NewOktaGroupsClient builds a client for the Okta Groups API. httpClient
+// may be nil, in which case http.DefaultClient is used.
+func NewOktaGroupsClient(apiURL, apiToken string, cacheTTL time.Duration, httpClient *http.Client) *OktaGroupsClient {
+	if cacheTTL <= 0 {
+		cacheTTL = defaultGroupsCacheTTL
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &OktaGroupsClient{
+		APIToken:   apiToken,
+		APIURL:     strings.TrimSuffix(apiURL, "/"),
+		CacheTTL:   cacheTTL,
+		Timeout:    defaultGroupsRequestTimeout,
+		httpClient: httpClient,
+		cache:      map[string]cachedGroups{},
+	}
+}
+
+// GroupsForUser returns the group names the given Okta user id belongs to,
+// serving a cached result when it is within CacheTTL.
+func (c *OktaGroupsClient) GroupsForUser(userID string) ([]string, error) {
+	c.mu.Lock()
+	if cached, ok := c.cache[userID]; ok && time.Since(cached.fetchedAt) < c.CacheTTL {
+		c.mu.Unlock()
+		return cached.groups, nil
+	}
+	c.mu.Unlock()
+
+	groups, err := c.fetchGroups(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.cache[userID] = cachedGroups{groups: groups, fetchedAt: time.Now()}
+	c.mu.Unlock()
+
+	return groups, nil
+}
+
+func (c *OktaGroupsClient) fetchGroups(userID string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	groups := make([]string, 0)
+	nextURL := fmt.Sprintf("%s/api/v1/users/%s/groups", c.APIURL, url.PathEscape(userID))
+
+	for nextURL != "" {
+		req, err := http.NewRequest(http.MethodGet, nextURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Authorization", "SSWS "+c.APIToken)
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("okta: groups API request failed: %w", err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			_ = resp.Body.Close()
+			return nil, fmt.Errorf("okta: groups API returned status %d", resp.StatusCode)
+		}
+
+		var page []oktaGroup
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("okta: failed decoding groups API response: %w", err)
+		}
+
+		for _, g := range page {
+			if g.Profile.Name != "" {
+				groups = append(groups, g.Profile.Name)
+			}
+		}
+
+		nextURL = nextPageURL(resp.Header.Get("Link"))
+	}
+
+	return groups, nil
+}
+
+// nextPageURL extracts the `rel="next"` target from an RFC 5988 Link
+// header, as returned by the Okta Groups API when a result set spans
+// multiple pages.
+func nextPageURL(linkHeader string) string {
+	for _, part := range strings.Split(linkHeader, ",") {
+		part = strings.TrimSpace(part)
+		if !strings.Contains(part, `rel="next"`) {
+			continue
+		}
+		start := strings.Index(part, "<")
+		end := strings.Index(part, ">")
+		if start == -1 || end == -1 || end <= start {
+			continue
+		}
+		return part[start+1 : end]
+	}
+	return ""
+}
+
+// HasAnyAllowedGroup reports whether groups intersects allowedGroups. An
+// empty allowedGroups means no restriction is configured. Exported so
+// OktaTokenAuth can apply the same allowed_groups gate interactive login
+// does.
+func HasAnyAllowedGroup(groups, allowedGroups []string) bool {
+	if len(allowedGroups) == 0 {
+		return true
+	}
+	allowed := make(map[string]struct{}, len(allowedGroups))
+	for _, g := range allowedGroups {
+		allowed[g] = struct{}{}
+	}
+	for _, g := range groups {
+		if _, ok := allowed[g]; ok {
+			return true
+		}
+	}
+	return false
+}
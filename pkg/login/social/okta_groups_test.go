@@ -0,0 +1,95 @@
+package social
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOktaGroupsClient_FollowsLinkHeaderPagination(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		assert.Equal(t, "SSWS token-123", r.Header.Get("Authorization"))
+
+		if len(requests) == 1 {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/api/v1/users/u1/groups?after=page2>; rel="next"`, selfURL(r)))
+			_, _ = w.Write([]byte(`[{"profile":{"name":"devs"}}]`))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"profile":{"name":"admins"}}]`))
+	}))
+	defer server.Close()
+
+	client := NewOktaGroupsClient(server.URL, "token-123", time.Minute, nil)
+	groups, err := client.GroupsForUser("u1")
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{"devs", "admins"}, groups)
+	assert.Len(t, requests, 2, "the client should follow the Link: rel=\"next\" header to a second page")
+}
+
+// selfURL is a tiny helper so the handler above can construct an
+// absolute next-page URL pointing back at itself, mirroring how Okta
+// returns fully-qualified Link headers.
+func selfURL(r *http.Request) string {
+	return "http://" + r.Host
+}
+
+func TestOktaGroupsClient_CachesWithinTTL(t *testing.T) {
+	requestCount := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+		_, _ = w.Write([]byte(`[{"profile":{"name":"devs"}}]`))
+	}))
+	defer server.Close()
+
+	client := NewOktaGroupsClient(server.URL, "token-123", 50*time.Millisecond, nil)
+
+	_, err := client.GroupsForUser("u1")
+	require.NoError(t, err)
+	_, err = client.GroupsForUser("u1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, requestCount, "a second call within CacheTTL should be served from cache")
+
+	time.Sleep(60 * time.Millisecond)
+	_, err = client.GroupsForUser("u1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, requestCount, "once CacheTTL elapses, the client should re-fetch")
+}
+
+func TestOktaGroupsClient_RejectsNonOKStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	client := NewOktaGroupsClient(server.URL, "token-123", time.Minute, nil)
+	_, err := client.GroupsForUser("u1")
+	assert.Error(t, err)
+}
+
+func TestOktaGroupsClient_TimesOutOnSlowResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	client := NewOktaGroupsClient(server.URL, "token-123", time.Minute, nil)
+	client.Timeout = 5 * time.Millisecond
+
+	_, err := client.GroupsForUser("u1")
+	assert.Error(t, err, "a request exceeding Timeout must fail rather than hang the login")
+}
+
+func TestHasAnyAllowedGroup(t *testing.T) {
+	assert.True(t, HasAnyAllowedGroup([]string{"devs"}, nil), "no allowed_groups configured means no restriction")
+	assert.True(t, HasAnyAllowedGroup([]string{"devs", "admins"}, []string{"admins"}))
+	assert.False(t, HasAnyAllowedGroup([]string{"devs"}, []string{"admins"}))
+}
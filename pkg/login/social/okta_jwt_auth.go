@@ -0,0 +1,273 @@
+package social
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"gopkg.in/square/go-jose.v2"
+	jwtpkg "gopkg.in/square/go-jose.v2/jwt"
+)
+
+// clientAssertionType is the value Okta (and the OAuth2 JWT client
+// authentication RFC it follows) expects for `client_assertion_type`.
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// assertionLifetime is intentionally short: the assertion is minted fresh
+// for every token request, it is never reused.
+const assertionLifetime = 5 * time.Minute
+
+// JWTClientAuth signs a `client_assertion` JWT for Okta's Service App
+// (private_key_jwt) client authentication, and performs the associated
+// token endpoint requests in place of a client_secret.
+//
+// Configure it from either `jwk_json` (an RSA JWK) or `jwk_pem` (a PEM
+// encoded RSA or EC private key) plus `kid`.
+type JWTClientAuth struct {
+	ClientID string
+	TokenURL string
+	KeyID    string
+	Signer   crypto.Signer
+	Alg      jose.SignatureAlgorithm
+
+	httpClient *http.Client
+}
+
+// NewJWTClientAuthFromJWK builds a JWTClientAuth from an RSA private key
+// expressed as a JSON Web Key (the `jwk_json` ini setting).
+func NewJWTClientAuthFromJWK(clientID, tokenURL, kid string, rawJWK []byte) (*JWTClientAuth, error) {
+	var jwk jose.JSONWebKey
+	if err := json.Unmarshal(rawJWK, &jwk); err != nil {
+		return nil, fmt.Errorf("okta: failed parsing jwk_json: %w", err)
+	}
+	if jwk.IsPublic() {
+		return nil, fmt.Errorf("okta: jwk_json must contain a private key")
+	}
+	signer, ok := jwk.Key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("okta: jwk_json key type %T is not usable for signing", jwk.Key)
+	}
+
+	if kid == "" {
+		kid = jwk.KeyID
+	}
+
+	return &JWTClientAuth{
+		ClientID: clientID,
+		TokenURL: tokenURL,
+		KeyID:    kid,
+		Signer:   signer,
+		Alg:      jose.RS256,
+	}, nil
+}
+
+// NewJWTClientAuthFromPEM builds a JWTClientAuth from a PEM encoded RSA or
+// EC private key (the `jwk_pem` ini setting).
+func NewJWTClientAuthFromPEM(clientID, tokenURL, kid string, rawPEM []byte) (*JWTClientAuth, error) {
+	block, _ := pem.Decode(rawPEM)
+	if block == nil {
+		return nil, fmt.Errorf("okta: jwk_pem does not contain a PEM block")
+	}
+
+	signer, alg, err := parsePEMSigner(block)
+	if err != nil {
+		return nil, fmt.Errorf("okta: failed parsing jwk_pem: %w", err)
+	}
+
+	return &JWTClientAuth{
+		ClientID: clientID,
+		TokenURL: tokenURL,
+		KeyID:    kid,
+		Signer:   signer,
+		Alg:      alg,
+	}, nil
+}
+
+// assertion mints a fresh, short-lived client_assertion JWT: iss=sub=client_id,
+// aud=token endpoint, random jti, signed with the configured key.
+func (a *JWTClientAuth) assertion() (string, error) {
+	signerKey := jose.SigningKey{Algorithm: a.Alg, Key: a.Signer}
+	signerOpts := (&jose.SignerOptions{}).WithType("JWT")
+	if a.KeyID != "" {
+		signerOpts = signerOpts.WithHeader("kid", a.KeyID)
+	}
+
+	joseSigner, err := jose.NewSigner(signerKey, signerOpts)
+	if err != nil {
+		return "", fmt.Errorf("okta: failed creating jwt signer: %w", err)
+	}
+
+	jti, err := randomJTI()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := jwtpkg.Claims{
+		Issuer:   a.ClientID,
+		Subject:  a.ClientID,
+		Audience: jwtpkg.Audience{a.TokenURL},
+		Expiry:   jwtpkg.NewNumericDate(now.Add(assertionLifetime)),
+		IssuedAt: jwtpkg.NewNumericDate(now),
+		ID:       jti,
+	}
+
+	return jwtpkg.Signed(joseSigner).Claims(claims).CompactSerialize()
+}
+
+// parsePEMSigner accepts PKCS#1, PKCS#8 and EC private key PEM blocks and
+// returns a crypto.Signer together with the JWS algorithm it should be used
+// with (RS256 for RSA, ES256 for EC).
+func parsePEMSigner(block *pem.Block) (crypto.Signer, jose.SignatureAlgorithm, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, jose.RS256, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, jose.ES256, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, "", fmt.Errorf("unsupported private key encoding: %w", err)
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		return k, jose.ES256, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+func randomJTI() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("okta: failed generating jti: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Exchange trades an authorization code for a token at a.TokenURL,
+// authenticating with a freshly minted client_assertion instead of a
+// client_secret.
+func (a *JWTClientAuth) Exchange(ctx context.Context, code, redirectURL string) (*oauth2.Token, error) {
+	values := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {redirectURL},
+	}
+	return a.requestToken(ctx, values)
+}
+
+// TokenSource returns an oauth2.TokenSource that refreshes t using the same
+// client_assertion based authentication, for as long as t carries a refresh
+// token.
+func (a *JWTClientAuth) TokenSource(ctx context.Context, t *oauth2.Token) oauth2.TokenSource {
+	return oauth2.ReuseTokenSource(t, &jwtClientAuthTokenSource{ctx: ctx, auth: a, t: t})
+}
+
+// jwtClientAuthTokenSource implements oauth2.TokenSource by refreshing via
+// JWTClientAuth.requestToken whenever the wrapped token has expired.
+type jwtClientAuthTokenSource struct {
+	ctx  context.Context
+	auth *JWTClientAuth
+	t    *oauth2.Token
+}
+
+func (s *jwtClientAuthTokenSource) Token() (*oauth2.Token, error) {
+	if s.t.Valid() {
+		return s.t, nil
+	}
+	if s.t.RefreshToken == "" {
+		return nil, fmt.Errorf("okta: token expired and no refresh_token is available")
+	}
+
+	values := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {s.t.RefreshToken},
+	}
+	newToken, err := s.auth.requestToken(s.ctx, values)
+	if err != nil {
+		return nil, err
+	}
+
+	// Okta rotates the refresh token on every use, so the one we just spent
+	// is no longer valid: remember the newly issued token for the next
+	// refresh instead of re-requesting with the stale one.
+	if newToken.RefreshToken == "" {
+		newToken.RefreshToken = s.t.RefreshToken
+	}
+	s.t = newToken
+
+	return s.t, nil
+}
+
+func (a *JWTClientAuth) requestToken(ctx context.Context, values url.Values) (*oauth2.Token, error) {
+	assertion, err := a.assertion()
+	if err != nil {
+		return nil, err
+	}
+
+	values.Set("client_id", a.ClientID)
+	values.Set("client_assertion_type", clientAssertionType)
+	values.Set("client_assertion", assertion)
+
+	client := a.httpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequest(http.MethodPost, a.TokenURL, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("okta: token request failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("okta: token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var tokenResp struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+		IDToken      string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return nil, fmt.Errorf("okta: failed decoding token response: %w", err)
+	}
+
+	token := &oauth2.Token{
+		AccessToken:  tokenResp.AccessToken,
+		TokenType:    tokenResp.TokenType,
+		RefreshToken: tokenResp.RefreshToken,
+	}
+	if tokenResp.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+	}
+	if tokenResp.IDToken != "" {
+		token = token.WithExtra(map[string]interface{}{"id_token": tokenResp.IDToken})
+	}
+	return token, nil
+}
@@ -0,0 +1,67 @@
+package social
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestJWTClientAuthTokenSource_PersistsRotatedRefreshToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	var seenRefreshTokens []string
+	callCount := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		seenRefreshTokens = append(seenRefreshTokens, r.Form.Get("refresh_token"))
+		callCount++
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  fmt.Sprintf("access-%d", callCount),
+			"refresh_token": fmt.Sprintf("refresh-%d", callCount),
+			"expires_in":    3600,
+		})
+	}))
+	defer server.Close()
+
+	auth := &JWTClientAuth{
+		ClientID: "client-id",
+		TokenURL: server.URL,
+		Signer:   key,
+		Alg:      jose.RS256,
+		httpClient: &http.Client{
+			Transport: http.DefaultTransport,
+		},
+	}
+
+	source := &jwtClientAuthTokenSource{
+		ctx:  context.Background(),
+		auth: auth,
+		t:    &oauth2.Token{RefreshToken: "refresh-0"},
+	}
+
+	_, err = source.Token()
+	require.NoError(t, err)
+
+	// Simulate time passing so the freshly issued token is now due for
+	// another refresh.
+	source.t.Expiry = time.Now().Add(-time.Hour)
+
+	_, err = source.Token()
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"refresh-0", "refresh-1"}, seenRefreshTokens,
+		"the second refresh must use the token returned by the first, not the original")
+}
@@ -1,14 +1,15 @@
 package social
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/grafana/grafana/pkg/models"
 	"golang.org/x/oauth2"
-	"gopkg.in/square/go-jose.v2/jwt"
 )
 
 type SocialOkta struct {
@@ -18,6 +19,22 @@ type SocialOkta struct {
 	allowedGroups     []string
 	allowSignup       bool
 	roleAttributePath string
+	roleAttributeExpr *RoleExpressionEvaluator
+	verifier          *IDTokenVerifier
+
+	// jwtClientAuth, when set, authenticates token endpoint requests with a
+	// signed client_assertion (Okta's "Service App" flow) instead of the
+	// oauth2.Config client_secret.
+	jwtClientAuth *JWTClientAuth
+
+	// groupsClient, when set, resolves a user's groups from Okta's admin
+	// Groups API instead of relying on the userinfo response.
+	groupsClient *OktaGroupsClient
+
+	// groupMappingReconciler, when set, is what ReconcileGroupMappings uses
+	// to sync org/team memberships from the user's Okta groups. See
+	// SetGroupMappingReconciler.
+	groupMappingReconciler *GroupMappingReconciler
 }
 
 type OktaClaims struct {
@@ -39,6 +56,87 @@ type OktaUserInfoJson struct {
 	rawJSON     []byte
 }
 
+// NewSocialOkta builds a SocialOkta provider, wiring up the shared
+// IDTokenVerifier used to check the signature of the id_token returned by
+// Okta. jwksURL may be left empty to discover it from issuerURL's
+// .well-known/openid-configuration document.
+func NewSocialOkta(base *SocialBase, apiUrl, jwksURL, issuerURL, clientID string, signatureAlgorithms []string,
+	jwksCacheTTL time.Duration, skipVerify bool, allowedDomains, allowedGroups []string, allowSignup bool,
+	roleAttributePath string) *SocialOkta {
+	return &SocialOkta{
+		SocialBase:        base,
+		apiUrl:            apiUrl,
+		allowedDomains:    allowedDomains,
+		allowedGroups:     allowedGroups,
+		allowSignup:       allowSignup,
+		roleAttributePath: roleAttributePath,
+		verifier: NewIDTokenVerifier(IDTokenVerifierConfig{
+			JWKSURL:             jwksURL,
+			IssuerURL:           issuerURL,
+			ClientID:            clientID,
+			SignatureAlgorithms: signatureAlgorithms,
+			CacheTTL:            jwksCacheTTL,
+			SkipVerify:          skipVerify,
+		}, nil),
+	}
+}
+
+// SetRoleAttributeExpr enables CEL-based role extraction, taking precedence
+// over role_attribute_path when both are configured.
+func (s *SocialOkta) SetRoleAttributeExpr(evaluator *RoleExpressionEvaluator) {
+	s.roleAttributeExpr = evaluator
+}
+
+// SetGroupsClient enables resolving group membership via Okta's admin
+// Groups API (configured with an `api_token`) instead of the userinfo
+// response's `groups` claim.
+func (s *SocialOkta) SetGroupsClient(client *OktaGroupsClient) {
+	s.groupsClient = client
+}
+
+// SetGroupMappingReconciler enables syncing org and team memberships from
+// group_mappings through ReconcileGroupMappings. UserInfo does not run the
+// sync itself: memberships change only when a caller invokes
+// ReconcileGroupMappings with the Grafana user created/matched for a login.
+func (s *SocialOkta) SetGroupMappingReconciler(r *GroupMappingReconciler) {
+	s.groupMappingReconciler = r
+}
+
+// ReconcileGroupMappings syncs userID's org and team memberships against
+// group_mappings for the given Okta groups, if a reconciler is configured.
+func (s *SocialOkta) ReconcileGroupMappings(userID int64, groups []string) error {
+	if s.groupMappingReconciler == nil {
+		return nil
+	}
+	return s.groupMappingReconciler.Reconcile(userID, groups)
+}
+
+// SetJWTClientAuth enables private_key_jwt client authentication for this
+// provider's token endpoint requests, used instead of a client_secret for
+// Okta Service Apps.
+func (s *SocialOkta) SetJWTClientAuth(auth *JWTClientAuth) {
+	s.jwtClientAuth = auth
+}
+
+// Exchange trades an authorization code for a token, authenticating with the
+// signed client_assertion when jwk_json/jwk_pem is configured, falling back
+// to the standard client_secret exchange otherwise.
+func (s *SocialOkta) Exchange(ctx context.Context, code, redirectURL string) (*oauth2.Token, error) {
+	if s.jwtClientAuth != nil {
+		return s.jwtClientAuth.Exchange(ctx, code, redirectURL)
+	}
+	return s.Config.Exchange(ctx, code)
+}
+
+// TokenSource returns an oauth2.TokenSource that keeps t valid, refreshing
+// it with the configured client_assertion when jwt client auth is enabled.
+func (s *SocialOkta) TokenSource(ctx context.Context, t *oauth2.Token) oauth2.TokenSource {
+	if s.jwtClientAuth != nil {
+		return s.jwtClientAuth.TokenSource(ctx, t)
+	}
+	return s.Config.TokenSource(ctx, t)
+}
+
 func (s *SocialOkta) Type() int {
 	return int(models.OKTA)
 }
@@ -57,14 +155,10 @@ func (s *SocialOkta) UserInfo(client *http.Client, token *oauth2.Token) (*BasicU
 		return nil, fmt.Errorf("No id_token found")
 	}
 
-	parsedToken, err := jwt.ParseSigned(idToken.(string))
-	if err != nil {
-		return nil, fmt.Errorf("Error parsing id token")
-	}
-
 	var claims OktaClaims
-	if err := parsedToken.UnsafeClaimsWithoutVerification(&claims); err != nil {
-		return nil, fmt.Errorf("Error getting claims from id token")
+	rawClaims, err := s.verifier.Verify(idToken.(string), &claims)
+	if err != nil {
+		return nil, fmt.Errorf("error verifying id token: %w", err)
 	}
 
 	email := claims.extractEmail()
@@ -75,8 +169,19 @@ func (s *SocialOkta) UserInfo(client *http.Client, token *oauth2.Token) (*BasicU
 
 	var data OktaUserInfoJson
 	s.extractAPI(&data, client)
-	role := s.extractRole(&data)
-	groups := s.GetGroups(client)
+	groups, err := s.getGroups(claims.ID, &data, client)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving groups: %w", err)
+	}
+
+	if !HasAnyAllowedGroup(groups, s.allowedGroups) {
+		return nil, errors.New("error getting user info: user not in any of the allowed_groups")
+	}
+
+	role, err := s.extractRole(&data, rawClaims, groups, email)
+	if err != nil {
+		return nil, fmt.Errorf("error extracting role: %w", err)
+	}
 
 	return &BasicUserInfo{
 		Id:     claims.ID,
@@ -88,6 +193,9 @@ func (s *SocialOkta) UserInfo(client *http.Client, token *oauth2.Token) (*BasicU
 	}, nil
 }
 
+// GetGroups returns the groups reported by the userinfo endpoint. It is
+// only populated when Okta's "groups" claim/scope is configured; use
+// SetGroupsClient for an authoritative source that doesn't depend on that.
 func (s *SocialOkta) GetGroups(client *http.Client) []string {
 	var data OktaUserInfoJson
 	groups := make([]string, 0)
@@ -98,6 +206,24 @@ func (s *SocialOkta) GetGroups(client *http.Client) []string {
 	return groups
 }
 
+// getGroups resolves the authoritative group list for a user: the Okta
+// Groups API when a groupsClient is configured, falling back to whatever
+// userinfo reported (data is already populated by the caller).
+func (s *SocialOkta) getGroups(userID string, data *OktaUserInfoJson, client *http.Client) ([]string, error) {
+	if s.groupsClient != nil {
+		groups, err := s.groupsClient.GroupsForUser(userID)
+		if err != nil {
+			return nil, err
+		}
+		return groups, nil
+	}
+
+	if len(data.Groups) > 0 {
+		return data.Groups, nil
+	}
+	return []string{}, nil
+}
+
 func (s *SocialOkta) extractAPI(data *OktaUserInfoJson, client *http.Client) bool {
 	rawUserInfoResponse, err := HttpGet(client, s.apiUrl)
 	if err != nil {
@@ -127,12 +253,29 @@ func (claims *OktaClaims) extractEmail() string {
 	return claims.Email
 }
 
-func (s *SocialOkta) extractRole(data *OktaUserInfoJson) string {
+// extractRole resolves the Grafana role to assign. role_attribute_expr, when
+// configured, takes precedence; role_attribute_path is used as a fallback.
+func (s *SocialOkta) extractRole(data *OktaUserInfoJson, claims map[string]interface{}, groups []string, email string) (string, error) {
+	if s.roleAttributeExpr != nil {
+		role, err := s.roleAttributeExpr.Evaluate(RoleExprInput{
+			Claims:   claims,
+			UserInfo: jsonToMap(data.rawJSON),
+			Groups:   groups,
+			Email:    email,
+		})
+		if err != nil {
+			return "", err
+		}
+		if role != "" {
+			return role, nil
+		}
+	}
+
 	if s.roleAttributePath != "" {
 		role := s.searchJSONForAttr(s.roleAttributePath, data.rawJSON)
 		if role != "" {
-			return role
+			return role, nil
 		}
 	}
-	return ""
+	return "", nil
 }
@@ -0,0 +1,118 @@
+package social
+
+import (
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// oktaEmailClaim is the one claim these tests add beyond the standard
+// jwt.Claims fields: UserInfo reads the user's email from the verified
+// id_token (not from the userinfo endpoint) and rejects a token without one.
+type oktaEmailClaim struct {
+	Email string `json:"email"`
+}
+
+// signIDToken signs claims for an Okta id_token, mirroring jwks_test.go's
+// signToken but carrying oktaEmailClaim instead of testClaims.
+func signIDToken(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.Claims, extra oktaEmailClaim) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithHeader("kid", kid))
+	require.NoError(t, err)
+	token, err := jwt.Signed(signer).Claims(claims).Claims(extra).CompactSerialize()
+	require.NoError(t, err)
+	return token
+}
+
+func TestSocialOkta_UserInfo_RejectsInvalidSignature(t *testing.T) {
+	_, pub := newKeyPair(t, "kid-1")
+	jwks := jwksServer(t, pub)
+	defer jwks.Close()
+
+	okta := NewSocialOkta(&SocialBase{log: log.New("test.okta")}, "", jwks.URL,
+		"https://example.okta.com", "client-id", nil, 0, false, nil, nil, true, "")
+
+	// Signed with a key the JWKS endpoint never served, simulating a forged
+	// or tampered id_token.
+	forgingKey, _ := newKeyPair(t, "kid-1")
+	token := signIDToken(t, forgingKey, "kid-1", jwt.Claims{
+		Issuer:   "https://example.okta.com",
+		Audience: jwt.Audience{"client-id"},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}, oktaEmailClaim{Email: "ada@example.com"})
+
+	oauthToken := (&oauth2.Token{}).WithExtra(map[string]interface{}{"id_token": token})
+
+	_, err := okta.UserInfo(http.DefaultClient, oauthToken)
+	assert.Error(t, err)
+}
+
+func TestSocialOkta_UserInfo_RejectsUserNotInAllowedGroups(t *testing.T) {
+	key, pub := newKeyPair(t, "kid-1")
+	jwks := jwksServer(t, pub)
+	defer jwks.Close()
+
+	userInfo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"email":"ada@example.com","groups":["some-other-group"]}`))
+	}))
+	defer userInfo.Close()
+
+	okta := NewSocialOkta(&SocialBase{log: log.New("test.okta")}, userInfo.URL, jwks.URL,
+		"https://example.okta.com", "client-id", nil, 0, false, nil,
+		[]string{"okta-grafana-admins"}, true, "")
+
+	token := signIDToken(t, key, "kid-1", jwt.Claims{
+		Subject:  "user-1",
+		Issuer:   "https://example.okta.com",
+		Audience: jwt.Audience{"client-id"},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}, oktaEmailClaim{Email: "ada@example.com"})
+
+	oauthToken := (&oauth2.Token{}).WithExtra(map[string]interface{}{"id_token": token})
+
+	_, err := okta.UserInfo(http.DefaultClient, oauthToken)
+	require.Error(t, err, "a user whose userinfo groups don't intersect allowed_groups must be rejected")
+}
+
+func TestSocialOkta_UserInfo_RoleAttributeExprTakesPrecedenceOverPath(t *testing.T) {
+	key, pub := newKeyPair(t, "kid-1")
+	jwks := jwksServer(t, pub)
+	defer jwks.Close()
+
+	userInfo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"email":"ada@example.com","groups":["okta-grafana-admins"],"attributes":{"role":["Viewer"]}}`))
+	}))
+	defer userInfo.Close()
+
+	evaluator, err := NewRoleExpressionEvaluator(`"okta-grafana-admins" in groups ? "Admin" : "Viewer"`)
+	require.NoError(t, err)
+
+	okta := NewSocialOkta(&SocialBase{log: log.New("test.okta")}, userInfo.URL, jwks.URL,
+		"https://example.okta.com", "client-id", nil, 0, false, nil, nil, true, "attributes.role")
+	okta.SetRoleAttributeExpr(evaluator)
+
+	token := signIDToken(t, key, "kid-1", jwt.Claims{
+		Subject:  "user-1",
+		Issuer:   "https://example.okta.com",
+		Audience: jwt.Audience{"client-id"},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}, oktaEmailClaim{Email: "ada@example.com"})
+
+	oauthToken := (&oauth2.Token{}).WithExtra(map[string]interface{}{"id_token": token})
+
+	info, err := okta.UserInfo(http.DefaultClient, oauthToken)
+	require.NoError(t, err)
+
+	// role_attribute_path alone would have resolved to "Viewer" from the
+	// userinfo attributes; role_attribute_expr must win instead.
+	assert.Equal(t, "Admin", info.Role)
+}
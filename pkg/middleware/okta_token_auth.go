@@ -0,0 +1,315 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/login/social"
+	"github.com/grafana/grafana/pkg/models"
+	"gopkg.in/macaron.v1"
+)
+
+// defaultReconcileCacheTTL bounds how often GroupMappingReconciler.Reconcile
+// runs for the same user. Without it, every authenticated API request would
+// pay for a full org/team reconciliation (several bus.Dispatch round-trips
+// each), unlike the JWKS and Groups API clients this middleware also uses,
+// which already cache.
+const defaultReconcileCacheTTL = 5 * time.Minute
+
+// OktaTokenAuthConfig configures OktaTokenAuth. Verifier is required;
+// everything else mirrors the matching `[auth.okta]` ini settings so the
+// same trust and mapping rules apply whether a user arrived via interactive
+// login or a bearer token.
+type OktaTokenAuthConfig struct {
+	Verifier *social.IDTokenVerifier
+
+	// AccessTokenAudience is the expected `aud` claim for an Okta
+	// access_token, e.g. `api://default`. Okta access tokens are normally
+	// audienced to the authorization server's resource identifier rather
+	// than the OAuth client_id Verifier checks id_tokens against, so a
+	// bearer token is tried against ClientID first and, on failure, against
+	// this audience. Leave empty to only accept id_tokens as bearer tokens.
+	AccessTokenAudience string
+
+	// GroupsClient, when set, is consulted for a user's groups whenever the
+	// bearer token itself carries none (the common case for an Okta
+	// access_token, which by default has no `groups` claim). This keeps
+	// group resolution consistent with interactive login instead of
+	// silently treating such tokens as belonging to no groups.
+	GroupsClient *social.OktaGroupsClient
+
+	// IntrospectionURL, when set, is called to confirm the token has not
+	// been revoked before it is trusted.
+	IntrospectionURL string
+	ClientID         string
+	ClientSecret     string
+
+	AllowSignUp    bool
+	AllowedDomains []string
+
+	// AllowedGroups mirrors SocialOkta's allowed_groups: a user whose
+	// resolved groups don't intersect it is rejected here the same way
+	// interactive login rejects them in UserInfo, so a bearer token can't
+	// bypass that restriction.
+	AllowedGroups []string
+
+	// RoleAttributeExpr assigns a default org role to newly auto-provisioned
+	// users when GroupMappingReconciler is not configured; it is ignored
+	// for users that already exist.
+	RoleAttributeExpr *social.RoleExpressionEvaluator
+
+	GroupMappingReconciler *social.GroupMappingReconciler
+
+	// ReconcileCacheTTL bounds how often GroupMappingReconciler.Reconcile is
+	// run for the same signed-in user. Defaults to defaultReconcileCacheTTL.
+	ReconcileCacheTTL time.Duration
+
+	HTTPClient *http.Client
+}
+
+// OktaTokenAuth lets API clients authenticate with an Okta-issued bearer
+// token (access_token or id_token) in place of a Grafana session, mapping
+// the token to a Grafana user with the same group/role rules used for
+// interactive Okta logins.
+type OktaTokenAuth struct {
+	cfg  OktaTokenAuthConfig
+	log  log.Logger
+	http *http.Client
+
+	reconcileMu  sync.Mutex
+	reconciledAt map[int64]time.Time
+}
+
+// NewOktaTokenAuth builds an OktaTokenAuth from cfg.
+func NewOktaTokenAuth(cfg OktaTokenAuthConfig) *OktaTokenAuth {
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if cfg.ReconcileCacheTTL <= 0 {
+		cfg.ReconcileCacheTTL = defaultReconcileCacheTTL
+	}
+	return &OktaTokenAuth{
+		cfg:          cfg,
+		log:          log.New("middleware.okta-token-auth"),
+		http:         httpClient,
+		reconciledAt: map[int64]time.Time{},
+	}
+}
+
+type oktaBearerClaims struct {
+	Subject string   `json:"sub"`
+	Email   string   `json:"email"`
+	Groups  []string `json:"groups"`
+}
+
+// Middleware returns a macaron.Handler that authenticates the request as
+// the Grafana user corresponding to an `Authorization: Bearer <okta-token>`
+// header. Requests without such a header, or one this provider doesn't
+// recognize, fall through unauthenticated so session-based auth still runs.
+func (a *OktaTokenAuth) Middleware() macaron.Handler {
+	return func(c *models.ReqContext) {
+		rawToken := bearerToken(c.Req.Header.Get("Authorization"))
+		if rawToken == "" {
+			return
+		}
+
+		var claims oktaBearerClaims
+		rawClaims, err := a.cfg.Verifier.Verify(rawToken, &claims)
+		if err != nil {
+			// An id_token's `aud` is the OAuth client_id, which is what
+			// Verify just checked. An Okta access_token is audienced to the
+			// authorization server's resource identifier instead, so retry
+			// against that before giving up on the token.
+			if a.cfg.AccessTokenAudience == "" {
+				a.log.Debug("rejecting okta bearer token", "error", err)
+				return
+			}
+			rawClaims, err = a.cfg.Verifier.VerifyAudience(rawToken, &claims, a.cfg.AccessTokenAudience)
+			if err != nil {
+				a.log.Debug("rejecting okta bearer token", "error", err)
+				return
+			}
+		}
+
+		if a.cfg.IntrospectionURL != "" {
+			active, err := a.introspect(rawToken)
+			if err != nil {
+				a.log.Warn("okta token introspection failed", "error", err)
+				return
+			}
+			if !active {
+				a.log.Debug("rejecting revoked okta bearer token", "sub", claims.Subject)
+				return
+			}
+		}
+
+		if claims.Email == "" {
+			a.log.Debug("rejecting okta bearer token with no email claim", "sub", claims.Subject)
+			return
+		}
+		if !emailDomainAllowed(claims.Email, a.cfg.AllowedDomains) {
+			a.log.Debug("rejecting okta bearer token: email domain not allowed", "email", claims.Email)
+			return
+		}
+
+		groups := claims.Groups
+		if len(groups) == 0 && a.cfg.GroupsClient != nil {
+			// Okta access tokens don't carry a `groups` claim by default;
+			// fall back to the same Groups API source interactive login
+			// uses so permissions stay consistent either way.
+			fetched, err := a.cfg.GroupsClient.GroupsForUser(claims.Subject)
+			if err != nil {
+				a.log.Error("failed fetching okta groups for bearer token", "sub", claims.Subject, "error", err)
+			} else {
+				groups = fetched
+			}
+		}
+
+		if !social.HasAnyAllowedGroup(groups, a.cfg.AllowedGroups) {
+			a.log.Debug("rejecting okta bearer token: user not in any of the allowed_groups", "email", claims.Email)
+			return
+		}
+
+		signedInUser, err := a.resolveUser(claims, rawClaims, groups)
+		if err != nil {
+			a.log.Error("failed resolving user for okta bearer token", "email", claims.Email, "error", err)
+			return
+		}
+		if signedInUser == nil {
+			return
+		}
+
+		if a.cfg.GroupMappingReconciler != nil && a.shouldReconcile(signedInUser.UserId) {
+			if err := a.cfg.GroupMappingReconciler.Reconcile(signedInUser.UserId, groups); err != nil {
+				a.log.Error("failed reconciling group mappings for okta bearer token", "email", claims.Email, "error", err)
+			}
+		}
+
+		c.SignedInUser = signedInUser
+		c.IsSignedIn = true
+	}
+}
+
+// shouldReconcile reports whether userID is due for another
+// GroupMappingReconciler.Reconcile pass, and if so marks it as reconciled
+// now. This keeps reconciliation — several bus.Dispatch round-trips — from
+// running on every single authenticated API request for the same user.
+func (a *OktaTokenAuth) shouldReconcile(userID int64) bool {
+	a.reconcileMu.Lock()
+	defer a.reconcileMu.Unlock()
+
+	if last, ok := a.reconciledAt[userID]; ok && time.Since(last) < a.cfg.ReconcileCacheTTL {
+		return false
+	}
+	a.reconciledAt[userID] = time.Now()
+	return true
+}
+
+// resolveUser looks up the Grafana user matching claims.Email, auto
+// provisioning it when AllowSignUp is set and no such user exists yet. A
+// freshly provisioned user's org role is seeded from RoleAttributeExpr when
+// GroupMappingReconciler isn't configured to set it instead. groups is the
+// caller's fully resolved group list (bearer token claim or GroupsClient
+// fallback), not necessarily claims.Groups.
+func (a *OktaTokenAuth) resolveUser(claims oktaBearerClaims, rawClaims map[string]interface{}, groups []string) (*models.SignedInUser, error) {
+	query := &models.GetSignedInUserQuery{Login: claims.Email}
+	err := bus.Dispatch(query)
+
+	switch {
+	case err == nil:
+		return query.Result, nil
+	case err != models.ErrUserNotFound:
+		return nil, err
+	case !a.cfg.AllowSignUp:
+		return nil, nil
+	}
+
+	createCmd := &models.CreateUserCommand{
+		Login: claims.Email,
+		Email: claims.Email,
+	}
+	if a.cfg.GroupMappingReconciler == nil && a.cfg.RoleAttributeExpr != nil {
+		role, err := a.cfg.RoleAttributeExpr.Evaluate(social.RoleExprInput{
+			Claims: rawClaims,
+			Groups: groups,
+			Email:  claims.Email,
+		})
+		if err != nil {
+			return nil, err
+		}
+		createCmd.DefaultOrgRole = role
+	}
+	if err := bus.Dispatch(createCmd); err != nil {
+		return nil, err
+	}
+
+	query = &models.GetSignedInUserQuery{Login: claims.Email}
+	if err := bus.Dispatch(query); err != nil {
+		return nil, err
+	}
+	return query.Result, nil
+}
+
+// introspect calls IntrospectionURL (RFC 7662) and reports whether the
+// token is still active.
+func (a *OktaTokenAuth) introspect(token string) (bool, error) {
+	values := url.Values{"token": {token}}
+	req, err := http.NewRequest(http.MethodPost, a.cfg.IntrospectionURL, strings.NewReader(values.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if a.cfg.ClientID != "" {
+		req.SetBasicAuth(a.cfg.ClientID, a.cfg.ClientSecret)
+	}
+
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var result struct {
+		Active bool `json:"active"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	return result.Active, nil
+}
+
+func bearerToken(header string) string {
+	const prefix = "Bearer "
+	if !strings.HasPrefix(header, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(header, prefix)
+}
+
+// emailDomainAllowed mirrors the allowlist semantics of the social
+// package's provider.IsEmailAllowed: no domains configured means no
+// restriction.
+func emailDomainAllowed(email string, allowedDomains []string) bool {
+	if len(allowedDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at == -1 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range allowedDomains {
+		if strings.ToLower(allowed) == domain {
+			return true
+		}
+	}
+	return false
+}
+
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"github.com/grafana/grafana/pkg/login/social"
+	"gopkg.in/ini.v1"
+)
+
+// NewOktaTokenAuthFromIni builds an OktaTokenAuth from the same `[auth.okta]`
+// ini section LoadOktaConfig reads, reusing the verifier and optional
+// group/role collaborators already wired up for interactive login so a
+// bearer token is trusted and mapped to roles the same way a browser login
+// would be.
+//
+// This is the integration point, not the integration itself: nothing in
+// this package's current scope registers the returned handler on the
+// running server's macaron instance. The real hook is a
+// `m.Use(NewOktaTokenAuthFromIni(...).Middleware())` call alongside the
+// session-based auth middleware, in the server bootstrap code (outside this
+// package's current scope).
+func NewOktaTokenAuthFromIni(sec *ini.Section, verifier *social.IDTokenVerifier,
+	groupsClient *social.OktaGroupsClient, reconciler *social.GroupMappingReconciler,
+	roleAttributeExpr *social.RoleExpressionEvaluator) *OktaTokenAuth {
+	return NewOktaTokenAuth(OktaTokenAuthConfig{
+		Verifier:               verifier,
+		AccessTokenAudience:    sec.Key("access_token_audience").String(),
+		GroupsClient:           groupsClient,
+		IntrospectionURL:       sec.Key("introspection_url").String(),
+		ClientID:               sec.Key("client_id").String(),
+		ClientSecret:           sec.Key("client_secret").String(),
+		AllowSignUp:            sec.Key("allow_sign_up").MustBool(true),
+		AllowedDomains:         sec.Key("allowed_domains").Strings(","),
+		AllowedGroups:          sec.Key("allowed_groups").Strings(","),
+		RoleAttributeExpr:      roleAttributeExpr,
+		GroupMappingReconciler: reconciler,
+		ReconcileCacheTTL:      sec.Key("reconcile_cache_ttl").MustDuration(defaultReconcileCacheTTL),
+	})
+}
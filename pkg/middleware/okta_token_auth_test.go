@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/login/social"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/macaron.v1"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// testOktaToken spins up a fake JWKS endpoint and a verifier pointed at it,
+// and signs a token with the given claims, for exercising Middleware()
+// end-to-end without a real Okta tenant.
+type testOktaToken struct {
+	verifier *social.IDTokenVerifier
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+}
+
+func newTestOktaToken(t *testing.T) *testOktaToken {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pub := jose.JSONWebKey{Key: key.Public(), KeyID: "kid-1", Algorithm: "RS256", Use: "sig"}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{pub}})
+	}))
+
+	verifier := social.NewIDTokenVerifier(social.IDTokenVerifierConfig{
+		JWKSURL:   server.URL,
+		IssuerURL: "https://example.okta.com",
+		ClientID:  "client-id",
+	}, http.DefaultClient)
+
+	return &testOktaToken{verifier: verifier, server: server, key: key}
+}
+
+func (o *testOktaToken) close() {
+	o.verifier.Close()
+	o.server.Close()
+}
+
+func (o *testOktaToken) sign(t *testing.T, audience string, claims jwt.Claims, extra interface{}) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: o.key},
+		(&jose.SignerOptions{}).WithHeader("kid", "kid-1"))
+	require.NoError(t, err)
+	claims.Audience = jwt.Audience{audience}
+	token, err := jwt.Signed(signer).Claims(claims).Claims(extra).CompactSerialize()
+	require.NoError(t, err)
+	return token
+}
+
+func newTestReqContext(req *http.Request) *models.ReqContext {
+	return &models.ReqContext{Context: &macaron.Context{Req: macaron.Request{Request: req}}}
+}
+
+func TestOktaTokenAuth_ShouldReconcile_CachesPerUser(t *testing.T) {
+	a := NewOktaTokenAuth(OktaTokenAuthConfig{ReconcileCacheTTL: 20 * time.Millisecond})
+
+	assert.True(t, a.shouldReconcile(1), "first call for a user should reconcile")
+	assert.False(t, a.shouldReconcile(1), "a second call within the TTL should be skipped")
+	assert.True(t, a.shouldReconcile(2), "a different user is unaffected by user 1's cache entry")
+
+	time.Sleep(30 * time.Millisecond)
+	assert.True(t, a.shouldReconcile(1), "once the TTL elapses, the user is due for reconciliation again")
+}
+
+func TestOktaTokenAuth_ResolveUser_FallsBackGroupsIntoRoleExpr(t *testing.T) {
+	bus.ClearBusHandlers()
+	defer bus.ClearBusHandlers()
+
+	var created *models.CreateUserCommand
+	bus.AddHandler("test", func(q *models.GetSignedInUserQuery) error {
+		if created == nil {
+			return models.ErrUserNotFound
+		}
+		q.Result = &models.SignedInUser{UserId: 1, Login: q.Login}
+		return nil
+	})
+	bus.AddHandler("test", func(cmd *models.CreateUserCommand) error {
+		created = cmd
+		return nil
+	})
+
+	evaluator, err := social.NewRoleExpressionEvaluator(`"admins" in groups ? "Admin" : "Viewer"`)
+	require.NoError(t, err)
+
+	a := NewOktaTokenAuth(OktaTokenAuthConfig{
+		AllowSignUp:       true,
+		RoleAttributeExpr: evaluator,
+	})
+
+	_, err = a.resolveUser(oktaBearerClaims{Email: "ada@example.com"}, nil, []string{"admins"})
+	require.NoError(t, err)
+
+	require.NotNil(t, created)
+	assert.Equal(t, "Admin", created.DefaultOrgRole,
+		"the groups passed in (the bearer token claim or GroupsClient fallback), not claims.Groups, must drive the role expression")
+}
+
+func TestOktaTokenAuth_Middleware_RejectsUserNotInAllowedGroups(t *testing.T) {
+	bus.ClearBusHandlers()
+	defer bus.ClearBusHandlers()
+
+	bus.AddHandler("test", func(q *models.GetSignedInUserQuery) error {
+		q.Result = &models.SignedInUser{UserId: 1, Login: "ada@example.com"}
+		return nil
+	})
+
+	okta := newTestOktaToken(t)
+	defer okta.close()
+
+	a := NewOktaTokenAuth(OktaTokenAuthConfig{
+		Verifier:      okta.verifier,
+		AllowedGroups: []string{"okta-grafana-admins"},
+	})
+
+	token := okta.sign(t, "client-id", jwt.Claims{
+		Issuer: "https://example.okta.com",
+		Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}, map[string]interface{}{"email": "ada@example.com", "groups": []string{"some-other-group"}})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	c := newTestReqContext(req)
+
+	a.Middleware()(c)
+
+	assert.False(t, c.IsSignedIn, "a user whose groups don't intersect allowed_groups must not be signed in via a bearer token")
+}
+
+func TestOktaTokenAuth_Middleware_AcceptsUserInAllowedGroups(t *testing.T) {
+	bus.ClearBusHandlers()
+	defer bus.ClearBusHandlers()
+
+	bus.AddHandler("test", func(q *models.GetSignedInUserQuery) error {
+		q.Result = &models.SignedInUser{UserId: 1, Login: "ada@example.com"}
+		return nil
+	})
+
+	okta := newTestOktaToken(t)
+	defer okta.close()
+
+	a := NewOktaTokenAuth(OktaTokenAuthConfig{
+		Verifier:      okta.verifier,
+		AllowedGroups: []string{"okta-grafana-admins"},
+	})
+
+	token := okta.sign(t, "client-id", jwt.Claims{
+		Issuer: "https://example.okta.com",
+		Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}, map[string]interface{}{"email": "ada@example.com", "groups": []string{"okta-grafana-admins"}})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	c := newTestReqContext(req)
+
+	a.Middleware()(c)
+
+	require.True(t, c.IsSignedIn)
+	assert.Equal(t, int64(1), c.SignedInUser.UserId)
+}
+
+func TestOktaTokenAuth_Middleware_AcceptsAccessTokenAgainstItsOwnAudience(t *testing.T) {
+	bus.ClearBusHandlers()
+	defer bus.ClearBusHandlers()
+
+	bus.AddHandler("test", func(q *models.GetSignedInUserQuery) error {
+		q.Result = &models.SignedInUser{UserId: 1, Login: "ada@example.com"}
+		return nil
+	})
+
+	okta := newTestOktaToken(t)
+	defer okta.close()
+
+	a := NewOktaTokenAuth(OktaTokenAuthConfig{
+		Verifier:            okta.verifier,
+		AccessTokenAudience: "api://default",
+	})
+
+	// Audienced to the resource server, not the OAuth client_id, as a real
+	// Okta access_token would be.
+	token := okta.sign(t, "api://default", jwt.Claims{
+		Issuer: "https://example.okta.com",
+		Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}, map[string]interface{}{"email": "ada@example.com"})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	c := newTestReqContext(req)
+
+	a.Middleware()(c)
+
+	assert.True(t, c.IsSignedIn, "an access_token audienced to AccessTokenAudience should be accepted")
+}